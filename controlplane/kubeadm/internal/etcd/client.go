@@ -0,0 +1,213 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package etcd implements etcd client functionality used by the kubeadm
+// control plane controller to inspect and manage an etcd cluster running
+// as static pods on the workload cluster's control plane nodes.
+package etcd
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/clientv3"
+	pb "go.etcd.io/etcd/etcdserver/etcdserverpb"
+)
+
+// ErrNoMemberIDForPeerURL is returned when no etcd member can be found
+// whose PeerURLs match the requested peer URL. This is treated as a
+// non-fatal condition by callers: it usually means the member has
+// already been removed from the cluster (e.g. by a previous, partially
+// completed reconcile).
+var ErrNoMemberIDForPeerURL = errors.New("could not find member ID for peer URL")
+
+// etcdClient defines the subset of the etcd clientv3 API consumed by
+// Client. It exists so that tests can provide a fake implementation.
+type etcdClient interface {
+	MemberList(ctx context.Context) (*clientv3.MemberListResponse, error)
+	MemberRemove(ctx context.Context, id uint64) (*clientv3.MemberRemoveResponse, error)
+	MoveLeader(ctx context.Context, transfereeID uint64) (*clientv3.MoveLeaderResponse, error)
+	AlarmList(ctx context.Context) (*clientv3.AlarmResponse, error)
+	Status(ctx context.Context, endpoint string) (*clientv3.StatusResponse, error)
+	Defragment(ctx context.Context) (*clientv3.DefragmentResponse, error)
+	Compact(ctx context.Context, rev int64, opts ...clientv3.CompactOption) (*clientv3.CompactResponse, error)
+	Snapshot(ctx context.Context) (io.ReadCloser, error)
+	AlarmDisarm(ctx context.Context, m *clientv3.AlarmMember) (*clientv3.AlarmResponse, error)
+	Close() error
+}
+
+// Member is a member of an etcd cluster.
+type Member struct {
+	Name     string
+	ID       uint64
+	PeerURLs []string
+	IsLeader bool
+}
+
+// MemberAlarm represents an alarm type associated with a member.
+type MemberAlarm struct {
+	MemberID uint64
+	Type     pb.AlarmType
+}
+
+// Client wraps an etcd client formats the resulting member lists,
+// exposing the leader ID of the endpoint it is connected to.
+type Client struct {
+	EtcdClient etcdClient
+	// LeaderID is the member ID of the leader, as reported by the etcd
+	// endpoint this client is connected to.
+	LeaderID uint64
+	// Endpoint is the etcd endpoint this client is connected to, used to
+	// scope endpoint-specific calls such as Status.
+	Endpoint string
+}
+
+// Close closes the underlying etcd client.
+func (c *Client) Close() error {
+	return c.EtcdClient.Close()
+}
+
+// Members retrieves a list of etcd members.
+func (c *Client) Members(ctx context.Context) ([]*Member, error) {
+	response, err := c.EtcdClient.MemberList(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list etcd members using etcd client")
+	}
+
+	members := make([]*Member, 0, len(response.Members))
+	for _, m := range response.Members {
+		members = append(members, &Member{
+			Name:     m.Name,
+			ID:       m.ID,
+			PeerURLs: m.PeerURLs,
+			IsLeader: m.ID == c.LeaderID,
+		})
+	}
+
+	return members, nil
+}
+
+// Alarms retrieves all alarms currently raised for the etcd cluster.
+func (c *Client) Alarms(ctx context.Context) ([]MemberAlarm, error) {
+	response, err := c.EtcdClient.AlarmList(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list etcd alarms using etcd client")
+	}
+
+	alarms := make([]MemberAlarm, 0, len(response.Alarms))
+	for _, a := range response.Alarms {
+		alarms = append(alarms, MemberAlarm{MemberID: a.MemberID, Type: a.Alarm})
+	}
+	return alarms, nil
+}
+
+// GetMemberIDByPeerURL returns the member ID of the etcd member whose
+// PeerURLs contains peerURL. It returns ErrNoMemberIDForPeerURL if no
+// member matches, which callers can treat as "already removed".
+func (c *Client) GetMemberIDByPeerURL(ctx context.Context, peerURL string) (uint64, error) {
+	members, err := c.Members(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, member := range members {
+		for _, p := range member.PeerURLs {
+			if p == peerURL {
+				return member.ID, nil
+			}
+		}
+	}
+
+	return 0, ErrNoMemberIDForPeerURL
+}
+
+// RemoveMember removes the member with the given ID from the etcd
+// cluster. If the member has already been removed (e.g. by a previous
+// attempt) ErrNoMemberIDForPeerURL is returned, so callers can
+// distinguish it from other failures and treat it as success.
+func (c *Client) RemoveMember(ctx context.Context, id uint64) error {
+	_, err := c.EtcdClient.MemberRemove(ctx, id)
+	if err != nil && isMemberNotFound(err) {
+		return ErrNoMemberIDForPeerURL
+	}
+	return err
+}
+
+// MoveLeader transfers etcd cluster leadership to the member with the
+// given ID.
+func (c *Client) MoveLeader(ctx context.Context, transfereeID uint64) error {
+	_, err := c.EtcdClient.MoveLeader(ctx, transfereeID)
+	return err
+}
+
+func isMemberNotFound(err error) bool {
+	return strings.Contains(err.Error(), "member not found")
+}
+
+// Status returns the status, including the current revision, of the
+// endpoint this client is connected to.
+func (c *Client) Status(ctx context.Context) (*clientv3.StatusResponse, error) {
+	response, err := c.EtcdClient.Status(ctx, c.Endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get etcd status")
+	}
+	return response, nil
+}
+
+// Defragment defragments the member this client is connected to.
+func (c *Client) Defragment(ctx context.Context) error {
+	_, err := c.EtcdClient.Defragment(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to defragment etcd member")
+	}
+	return nil
+}
+
+// Compact compacts etcd's history before rev; rev itself is kept.
+func (c *Client) Compact(ctx context.Context, rev int64) error {
+	_, err := c.EtcdClient.Compact(ctx, rev, clientv3.WithCompactPhysical())
+	if err != nil {
+		return errors.Wrap(err, "failed to compact etcd")
+	}
+	return nil
+}
+
+// AlarmDisarm disarms the given alarm type for the etcd member it was
+// raised on.
+func (c *Client) AlarmDisarm(ctx context.Context, memberID uint64, alarmType pb.AlarmType) error {
+	_, err := c.EtcdClient.AlarmDisarm(ctx, &clientv3.AlarmMember{MemberID: memberID, Alarm: alarmType})
+	if err != nil {
+		return errors.Wrap(err, "failed to disarm etcd alarm")
+	}
+	return nil
+}
+
+// Snapshot streams a point-in-time snapshot of the etcd member this
+// client is connected to into sink.
+func (c *Client) Snapshot(ctx context.Context, sink io.Writer) error {
+	stream, err := c.EtcdClient.Snapshot(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to open etcd snapshot stream")
+	}
+	defer stream.Close()
+
+	if _, err := io.Copy(sink, stream); err != nil {
+		return errors.Wrap(err, "failed to read etcd snapshot stream")
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fake implements a fake etcd client for use in unit tests.
+package fake
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+
+	"go.etcd.io/etcd/clientv3"
+	pb "go.etcd.io/etcd/etcdserver/etcdserverpb"
+)
+
+// FakeEtcdClient is a fake implementation of the etcd client interface
+// consumed by etcd.Client, for use in unit tests.
+type FakeEtcdClient struct {
+	EtcdEndpoints []string
+
+	// Name identifies which node this client was generated for. It is
+	// only used to record call order in DefragmentLog; tests that don't
+	// care about ordering can leave it unset.
+	Name string
+	// DefragmentLog, if set, has Name appended to it every time
+	// Defragment is called, so tests can assert the order members were
+	// defragmented in.
+	DefragmentLog *[]string
+
+	ErrorResponse error
+	// MemberRemoveError, when set, is returned by MemberRemove instead of
+	// ErrorResponse. It allows tests to simulate a MemberRemove-specific
+	// failure (e.g. "member not found") without also failing MemberList.
+	MemberRemoveError error
+
+	MemberListResponse   *clientv3.MemberListResponse
+	MemberRemoveResponse *clientv3.MemberRemoveResponse
+	AlarmResponse        *clientv3.AlarmResponse
+	StatusResponse       *clientv3.StatusResponse
+	DefragmentResponse   *clientv3.DefragmentResponse
+	CompactResponse      *clientv3.CompactResponse
+
+	// SnapshotResponse is streamed back by Snapshot as the contents of
+	// the snapshot. SnapshotError, if set, is returned instead.
+	SnapshotResponse []byte
+	SnapshotError    error
+
+	AlarmDisarmResponse *clientv3.AlarmResponse
+	// AlarmDisarmError, when set, is returned by AlarmDisarm instead of
+	// ErrorResponse, so tests can fail a disarm without also failing the
+	// Defragment call that precedes it.
+	AlarmDisarmError error
+	// DefragmentLeavesNoSpaceAlarm, when set, makes Defragment leave any
+	// NOSPACE alarms in AlarmResponse untouched, simulating a
+	// defragmentation that did not free enough space. By default
+	// Defragment clears them, simulating a successful recovery.
+	DefragmentLeavesNoSpaceAlarm bool
+
+	MovedLeader       uint64
+	DefragmentedCount int
+	CompactedRevision int64
+	DisarmedAlarms    []clientv3.AlarmMember
+	// MemberRemoveCallCount counts every MemberRemove call, regardless
+	// of whether it returned an error, so tests can assert the RPC was
+	// never invoked (e.g. because a quorum guard blocked it upstream).
+	MemberRemoveCallCount int
+}
+
+// MemberList returns the configured MemberListResponse.
+func (c *FakeEtcdClient) MemberList(_ context.Context) (*clientv3.MemberListResponse, error) {
+	return c.MemberListResponse, c.ErrorResponse
+}
+
+// MemberRemove records that it was called and returns the configured
+// MemberRemoveResponse.
+func (c *FakeEtcdClient) MemberRemove(_ context.Context, _ uint64) (*clientv3.MemberRemoveResponse, error) {
+	c.MemberRemoveCallCount++
+	if c.MemberRemoveError != nil {
+		return c.MemberRemoveResponse, c.MemberRemoveError
+	}
+	return c.MemberRemoveResponse, c.ErrorResponse
+}
+
+// MoveLeader records the transferee ID it was called with and returns
+// the configured ErrorResponse.
+func (c *FakeEtcdClient) MoveLeader(_ context.Context, transfereeID uint64) (*clientv3.MoveLeaderResponse, error) {
+	if c.ErrorResponse == nil {
+		c.MovedLeader = transfereeID
+	}
+	return &clientv3.MoveLeaderResponse{}, c.ErrorResponse
+}
+
+// AlarmList returns the configured AlarmResponse.
+func (c *FakeEtcdClient) AlarmList(_ context.Context) (*clientv3.AlarmResponse, error) {
+	return c.AlarmResponse, c.ErrorResponse
+}
+
+// Status returns the configured StatusResponse.
+func (c *FakeEtcdClient) Status(_ context.Context, _ string) (*clientv3.StatusResponse, error) {
+	return c.StatusResponse, c.ErrorResponse
+}
+
+// Defragment records that it was called and, unless
+// DefragmentLeavesNoSpaceAlarm is set, clears any NOSPACE alarms from
+// AlarmResponse to simulate the reclaimed disk space clearing them. It
+// returns the configured DefragmentResponse.
+func (c *FakeEtcdClient) Defragment(_ context.Context) (*clientv3.DefragmentResponse, error) {
+	if c.ErrorResponse == nil {
+		c.DefragmentedCount++
+		if c.DefragmentLog != nil {
+			*c.DefragmentLog = append(*c.DefragmentLog, c.Name)
+		}
+		if !c.DefragmentLeavesNoSpaceAlarm && c.AlarmResponse != nil {
+			remaining := c.AlarmResponse.Alarms[:0]
+			for _, alarm := range c.AlarmResponse.Alarms {
+				if alarm.Alarm != pb.AlarmType_NOSPACE {
+					remaining = append(remaining, alarm)
+				}
+			}
+			c.AlarmResponse.Alarms = remaining
+		}
+	}
+	return c.DefragmentResponse, c.ErrorResponse
+}
+
+// Compact records the revision it was called with and returns the
+// configured CompactResponse.
+func (c *FakeEtcdClient) Compact(_ context.Context, rev int64, _ ...clientv3.CompactOption) (*clientv3.CompactResponse, error) {
+	if c.ErrorResponse == nil {
+		c.CompactedRevision = rev
+	}
+	return c.CompactResponse, c.ErrorResponse
+}
+
+// AlarmDisarm records the alarm member it was called with and returns
+// the configured AlarmDisarmResponse.
+func (c *FakeEtcdClient) AlarmDisarm(_ context.Context, m *clientv3.AlarmMember) (*clientv3.AlarmResponse, error) {
+	if c.AlarmDisarmError != nil {
+		return c.AlarmDisarmResponse, c.AlarmDisarmError
+	}
+	if c.ErrorResponse == nil {
+		c.DisarmedAlarms = append(c.DisarmedAlarms, *m)
+	}
+	return c.AlarmDisarmResponse, c.ErrorResponse
+}
+
+// Snapshot streams back the configured SnapshotResponse, or returns
+// SnapshotError if set.
+func (c *FakeEtcdClient) Snapshot(_ context.Context) (io.ReadCloser, error) {
+	if c.SnapshotError != nil {
+		return nil, c.SnapshotError
+	}
+	return ioutil.NopCloser(bytes.NewReader(c.SnapshotResponse)), nil
+}
+
+// Close is a no-op.
+func (c *FakeEtcdClient) Close() error {
+	return nil
+}
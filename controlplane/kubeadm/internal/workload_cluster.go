@@ -0,0 +1,114 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"sigs.k8s.io/cluster-api/controlplane/kubeadm/internal/etcd"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// labelNodeRoleMaster is the node label used by kubeadm to identify
+	// control plane nodes.
+	labelNodeRoleMaster = "node-role.kubernetes.io/master"
+
+	kubeadmConfigKey        = "kubeadm-config"
+	clusterConfigurationKey = "ClusterConfiguration"
+)
+
+// Workload defines operations on workload clusters.
+type Workload struct {
+	Client              ctrlclient.Client
+	etcdClientGenerator etcdClientFor
+}
+
+// etcdClientFor creates etcd clients connected to specific etcd members
+// of the workload cluster.
+type etcdClientFor interface {
+	forNode(ctx context.Context, name string) (*etcd.Client, error)
+}
+
+// NewWorkload returns a new Workload for the given client.
+func NewWorkload(client ctrlclient.Client, etcdClientGenerator etcdClientFor) *Workload {
+	return &Workload{
+		Client:              client,
+		etcdClientGenerator: etcdClientGenerator,
+	}
+}
+
+// getControlPlaneNodes returns the list of Nodes labeled as control
+// plane nodes in the workload cluster.
+func (w *Workload) getControlPlaneNodes(ctx context.Context) (*corev1.NodeList, error) {
+	nodes := &corev1.NodeList{}
+	labels := map[string]string{labelNodeRoleMaster: ""}
+	if err := w.Client.List(ctx, nodes, ctrlclient.MatchingLabels(labels)); err != nil {
+		return nil, errors.Wrap(err, "failed to list control plane nodes")
+	}
+	return nodes, nil
+}
+
+// UpdateEtcdVersionInKubeadmConfigMap sets the imageRepository and
+// imageTag fields of the etcd section of the kubeadm-config ConfigMap.
+func (w *Workload) UpdateEtcdVersionInKubeadmConfigMap(ctx context.Context, imageRepository, imageTag string) error {
+	configMapKey := ctrlclient.ObjectKey{Name: kubeadmConfigKey, Namespace: metav1.NamespaceSystem}
+	kubeadmConfigMap := &corev1.ConfigMap{}
+	if err := w.Client.Get(ctx, configMapKey, kubeadmConfigMap); err != nil {
+		return errors.Wrap(err, "unable to get kubeadm-config ConfigMap")
+	}
+
+	// ClusterConfiguration is kubeadm's own type, which this module does
+	// not vendor just to flip two nested etcd fields. Round-trip through
+	// a generic map so any fields we don't know about are left untouched.
+	config := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(kubeadmConfigMap.Data[clusterConfigurationKey]), &config); err != nil {
+		return errors.Wrap(err, "unable to decode ClusterConfiguration")
+	}
+
+	etcdConfig, _ := config["etcd"].(map[string]interface{})
+	if etcdConfig == nil {
+		etcdConfig = map[string]interface{}{}
+	}
+	local, _ := etcdConfig["local"].(map[string]interface{})
+	if local == nil {
+		local = map[string]interface{}{}
+	}
+	if local["imageRepository"] == imageRepository && local["imageTag"] == imageTag {
+		return nil
+	}
+	local["imageRepository"] = imageRepository
+	local["imageTag"] = imageTag
+	etcdConfig["local"] = local
+	config["etcd"] = etcdConfig
+
+	updated, err := yaml.Marshal(config)
+	if err != nil {
+		return errors.Wrap(err, "unable to encode ClusterConfiguration")
+	}
+
+	kubeadmConfigMap.Data[clusterConfigurationKey] = string(updated)
+	if err := w.Client.Update(ctx, kubeadmConfigMap); err != nil {
+		return errors.Wrap(err, "error updating kubeadm-config ConfigMap")
+	}
+	return nil
+}
@@ -0,0 +1,401 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	pb "go.etcd.io/etcd/etcdserver/etcdserverpb"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	"sigs.k8s.io/cluster-api/controlplane/kubeadm/internal/etcd"
+	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// etcdPeerPort is the port etcd listens on for peer traffic.
+const etcdPeerPort = 2380
+
+// EtcdHealthReport captures the result of a health check against a
+// single etcd member, including any alarms currently raised for it. A
+// zero-value Alarms slice means the member has no active alarms
+// (equivalent to pb.AlarmType_NONE).
+type EtcdHealthReport struct {
+	MemberID uint64
+	NodeName string
+	Alarms   []pb.AlarmType
+	Err      error
+}
+
+// HasAlarmType reports whether alarmType is currently raised for this
+// member.
+func (r EtcdHealthReport) HasAlarmType(alarmType pb.AlarmType) bool {
+	for _, a := range r.Alarms {
+		if a == alarmType {
+			return true
+		}
+	}
+	return false
+}
+
+// EtcdIsHealthy runs a series of checks against the etcd members known to
+// the workload cluster, and returns a map of node name to EtcdHealthReport.
+// Every control plane node gets an entry, with any failure to check it
+// recorded in Err, except nodes that are not in the etcd member list.
+func (w *Workload) EtcdIsHealthy(ctx context.Context) (map[string]EtcdHealthReport, error) {
+	controlPlaneNodes, err := w.getControlPlaneNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make(map[string]EtcdHealthReport)
+	for _, node := range controlPlaneNodes.Items {
+		name := node.Name
+		report := EtcdHealthReport{NodeName: name}
+
+		if node.Spec.ProviderID == "" {
+			report.Err = errors.New("missing provider ID")
+			response[name] = report
+			continue
+		}
+
+		etcdClient, err := w.etcdClientGenerator.forNode(ctx, name)
+		if err != nil {
+			report.Err = errors.Wrap(err, "failed to create etcd client")
+			response[name] = report
+			continue
+		}
+		defer etcdClient.Close()
+
+		members, err := etcdClient.Members(ctx)
+		if err != nil {
+			report.Err = errors.Wrap(err, "failed to list etcd members using etcd client")
+			response[name] = report
+			continue
+		}
+
+		member := etcdMemberForName(members, name)
+		if member == nil {
+			// This node is not (yet, or any longer) a member of the etcd
+			// cluster, there is nothing to report for it.
+			continue
+		}
+		report.MemberID = member.ID
+
+		alarms, err := etcdClient.Alarms(ctx)
+		if err != nil {
+			report.Err = errors.Wrap(err, "failed to list etcd alarms using etcd client")
+			response[name] = report
+			continue
+		}
+		for _, alarm := range alarms {
+			if alarm.MemberID == member.ID {
+				report.Alarms = append(report.Alarms, alarm.Type)
+			}
+		}
+
+		pod := &corev1.Pod{}
+		podKey := ctrlclient.ObjectKey{Name: staticPodName("etcd", name), Namespace: metav1.NamespaceSystem}
+		if err := w.Client.Get(ctx, podKey, pod); err != nil {
+			report.Err = errors.Wrapf(err, "failed to get etcd pod for node %q", name)
+			response[name] = report
+			continue
+		}
+		if !podIsReady(pod) {
+			report.Err = errors.Errorf("etcd pod for node %q is not ready", name)
+		}
+
+		response[name] = report
+	}
+
+	return response, nil
+}
+
+// EtcdClusterHealthyForRollingUpdate reports whether it is safe to start
+// replacing control plane machines: no etcd member may have an active
+// CORRUPT alarm, since rolling through nodes while data corruption is
+// unresolved risks losing the last good copy of the affected member's
+// data. The KubeadmControlPlane reconciler's rolling update logic must
+// consult this before replacing any control plane machine.
+func (w *Workload) EtcdClusterHealthyForRollingUpdate(ctx context.Context) (bool, error) {
+	health, err := w.EtcdIsHealthy(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, report := range health {
+		if report.HasAlarmType(pb.AlarmType_CORRUPT) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// RemoveEtcdMemberOption is a functional option for
+// Workload.RemoveEtcdMemberForMachine.
+type RemoveEtcdMemberOption interface {
+	ApplyToRemoveEtcdMember(*removeEtcdMemberConfig)
+}
+
+type removeEtcdMemberConfig struct {
+	snapshotOptions *EtcdSnapshotOptions
+}
+
+type removeEtcdMemberOptionFunc func(*removeEtcdMemberConfig)
+
+func (f removeEtcdMemberOptionFunc) ApplyToRemoveEtcdMember(c *removeEtcdMemberConfig) {
+	f(c)
+}
+
+// WithEtcdSnapshotBeforeRemove causes RemoveEtcdMemberForMachine to take
+// an etcd snapshot, using opts, before removing the member. The removal
+// is aborted, and the snapshot error returned, if the snapshot fails.
+//
+// For opts.HostPath this includes the snapshot Job itself: EtcdSnapshot
+// waits (up to etcdSnapshotJobTimeout) for the Job to complete, and the
+// removal is aborted if the Job fails or does not finish in time.
+func WithEtcdSnapshotBeforeRemove(opts EtcdSnapshotOptions) RemoveEtcdMemberOption {
+	return removeEtcdMemberOptionFunc(func(c *removeEtcdMemberConfig) {
+		c.snapshotOptions = &opts
+	})
+}
+
+// RemoveEtcdMemberForMachine removes the etcd member for the given
+// machine, if any, from the etcd cluster. The machine is matched to an
+// etcd member by peer URL rather than by name, so that the removal is
+// idempotent: a member that has already been removed (for example by a
+// previous, partially completed reconcile) is treated as success rather
+// than an error.
+func (w *Workload) RemoveEtcdMemberForMachine(ctx context.Context, machine *clusterv1.Machine, opts ...RemoveEtcdMemberOption) error {
+	log := ctrl.LoggerFrom(ctx)
+
+	var cfg removeEtcdMemberConfig
+	for _, opt := range opts {
+		opt.ApplyToRemoveEtcdMember(&cfg)
+	}
+
+	if machine == nil || machine.Status.NodeRef == nil {
+		// Nothing to do, the machine has no associated node.
+		return nil
+	}
+
+	controlPlaneNodes, err := w.getControlPlaneNodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	nodeRefName := machine.Status.NodeRef.Name
+	var victim *corev1.Node
+	numOtherNodes := 0
+	for i := range controlPlaneNodes.Items {
+		node := &controlPlaneNodes.Items[i]
+		if node.Name == nodeRefName {
+			victim = node
+			continue
+		}
+		numOtherNodes++
+	}
+	if victim == nil {
+		// The Node is already gone, most likely because a previous,
+		// partially completed reconcile deleted it ahead of this call.
+		// There is no peer URL left to match an etcd member against, so
+		// there is nothing left for this call to do.
+		log.V(5).Info("etcd member already removed: node not found", "node", nodeRefName)
+		return nil
+	}
+	if numOtherNodes < 1 {
+		return errors.Errorf("cannot remove etcd member for node %q: too few control plane nodes remaining", nodeRefName)
+	}
+
+	etcdClient, err := w.etcdClientGenerator.forNode(ctx, nodeRefName)
+	if err != nil {
+		return errors.Wrap(err, "failed to create etcd client")
+	}
+	defer etcdClient.Close()
+
+	peerURL, err := nodeEtcdPeerURL(victim)
+	if err != nil {
+		return err
+	}
+
+	memberID, err := etcdClient.GetMemberIDByPeerURL(ctx, peerURL)
+	if err != nil {
+		if errors.Is(err, etcd.ErrNoMemberIDForPeerURL) {
+			log.V(5).Info("etcd member already removed", "node", nodeRefName)
+			return nil
+		}
+		return errors.Wrapf(err, "failed to find etcd member for node %q", nodeRefName)
+	}
+
+	if err := NewEtcdQuorumGuard(w).AllowRemoval(ctx, etcdClient, memberID); err != nil {
+		return errors.Wrapf(err, "failed to remove etcd member for node %q", nodeRefName)
+	}
+
+	if cfg.snapshotOptions != nil {
+		if _, err := w.EtcdSnapshot(ctx, *cfg.snapshotOptions); err != nil {
+			return errors.Wrapf(err, "aborting removal of etcd member for node %q: failed to take etcd snapshot", nodeRefName)
+		}
+	}
+
+	if err := etcdClient.RemoveMember(ctx, memberID); err != nil {
+		if errors.Is(err, etcd.ErrNoMemberIDForPeerURL) {
+			log.V(5).Info("etcd member already removed", "node", nodeRefName)
+			return nil
+		}
+		return errors.Wrapf(err, "failed to remove etcd member for node %q", nodeRefName)
+	}
+
+	return nil
+}
+
+// ForwardEtcdLeadershipOption is a functional option for
+// Workload.ForwardEtcdLeadership.
+type ForwardEtcdLeadershipOption interface {
+	ApplyToForwardEtcdLeadership(*forwardEtcdLeadershipConfig)
+}
+
+type forwardEtcdLeadershipConfig struct {
+	snapshotOptions *EtcdSnapshotOptions
+}
+
+type forwardEtcdLeadershipOptionFunc func(*forwardEtcdLeadershipConfig)
+
+func (f forwardEtcdLeadershipOptionFunc) ApplyToForwardEtcdLeadership(c *forwardEtcdLeadershipConfig) {
+	f(c)
+}
+
+// WithEtcdSnapshotBeforeForwardLeadership causes ForwardEtcdLeadership to
+// take an etcd snapshot, using opts, before moving leadership. The move
+// is aborted, and the snapshot error returned, if the snapshot fails.
+//
+// As with WithEtcdSnapshotBeforeRemove, for opts.HostPath this includes
+// waiting for the snapshot Job itself to complete.
+func WithEtcdSnapshotBeforeForwardLeadership(opts EtcdSnapshotOptions) ForwardEtcdLeadershipOption {
+	return forwardEtcdLeadershipOptionFunc(func(c *forwardEtcdLeadershipConfig) {
+		c.snapshotOptions = &opts
+	})
+}
+
+// ForwardEtcdLeadership moves the etcd leadership to another node if the
+// given machine is the etcd leader.
+func (w *Workload) ForwardEtcdLeadership(ctx context.Context, machine *clusterv1.Machine, leaderCandidate *clusterv1.Machine, opts ...ForwardEtcdLeadershipOption) error {
+	if machine == nil || machine.Status.NodeRef == nil {
+		return nil
+	}
+
+	var cfg forwardEtcdLeadershipConfig
+	for _, opt := range opts {
+		opt.ApplyToForwardEtcdLeadership(&cfg)
+	}
+
+	nodeName := machine.Status.NodeRef.Name
+	etcdClient, err := w.etcdClientGenerator.forNode(ctx, nodeName)
+	if err != nil {
+		return errors.Wrap(err, "failed to create etcd client")
+	}
+	defer etcdClient.Close()
+
+	members, err := etcdClient.Members(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to list etcd members using etcd client")
+	}
+
+	currentMember := etcdMemberForName(members, nodeName)
+	if currentMember == nil || currentMember.ID != etcdClient.LeaderID {
+		// This node is not the etcd leader, there is nothing to forward.
+		return nil
+	}
+
+	var leaderCandidateMember *etcd.Member
+	if leaderCandidate != nil && leaderCandidate.Status.NodeRef != nil {
+		leaderCandidateMember = etcdMemberForName(members, leaderCandidate.Status.NodeRef.Name)
+		if leaderCandidateMember == nil {
+			return errors.Errorf("failed to get etcd member for leader candidate node %q", leaderCandidate.Status.NodeRef.Name)
+		}
+	} else {
+		for _, member := range members {
+			if member.ID != currentMember.ID {
+				leaderCandidateMember = member
+				break
+			}
+		}
+		if leaderCandidateMember == nil {
+			return errors.New("failed to find a candidate to forward etcd leadership to")
+		}
+	}
+
+	if err := NewEtcdQuorumGuard(w).AllowLeadershipMove(ctx, leaderCandidateMember); err != nil {
+		return errors.Wrapf(err, "failed to move etcd leader to node %q", leaderCandidateMember.Name)
+	}
+
+	if cfg.snapshotOptions != nil {
+		if _, err := w.EtcdSnapshot(ctx, *cfg.snapshotOptions); err != nil {
+			return errors.Wrapf(err, "aborting move of etcd leadership to node %q: failed to take etcd snapshot", leaderCandidateMember.Name)
+		}
+	}
+
+	if err := etcdClient.MoveLeader(ctx, leaderCandidateMember.ID); err != nil {
+		return errors.Wrapf(err, "failed to move etcd leader to node %q", leaderCandidateMember.Name)
+	}
+
+	return nil
+}
+
+func etcdMemberForName(members []*etcd.Member, name string) *etcd.Member {
+	for _, member := range members {
+		if member.Name == name {
+			return member
+		}
+	}
+	return nil
+}
+
+func etcdMemberForID(members []*etcd.Member, id uint64) *etcd.Member {
+	for _, member := range members {
+		if member.ID == id {
+			return member
+		}
+	}
+	return nil
+}
+
+func nodeEtcdPeerURL(node *corev1.Node) (string, error) {
+	if node == nil {
+		return "", errors.New("cannot determine etcd peer URL: node not found")
+	}
+	for _, address := range node.Status.Addresses {
+		if address.Type == corev1.NodeInternalIP {
+			return fmt.Sprintf("https://%s:%d", address.Address, etcdPeerPort), nil
+		}
+	}
+	return "", errors.Errorf("node %q has no internal IP address", node.Name)
+}
+
+func staticPodName(component, nodeName string) string {
+	return fmt.Sprintf("%s-%s", component, nodeName)
+}
+
+func podIsReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
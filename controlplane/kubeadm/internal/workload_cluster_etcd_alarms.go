@@ -0,0 +1,112 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	pb "go.etcd.io/etcd/etcdserver/etcdserverpb"
+
+	"sigs.k8s.io/cluster-api/controlplane/kubeadm/internal/etcd"
+)
+
+// EtcdRecoveryPolicy configures Workload.EtcdAutoRecoverAlarms.
+type EtcdRecoveryPolicy struct {
+	// AutoRecoverNoSpace enables automatic recovery of NOSPACE alarms: the
+	// affected member is defragmented and, if that frees enough space for
+	// the alarm to be disarmed, the alarm is cleared. CORRUPT alarms are
+	// never auto-disarmed regardless of this setting, since data
+	// corruption must be resolved by a human.
+	AutoRecoverNoSpace bool
+}
+
+// EtcdAutoRecoverAlarms attempts to automatically recover from active
+// etcd alarms according to policy, and returns the alarms that remain
+// active afterwards (either because policy did not cover them, or
+// because recovery was attempted and failed).
+func (w *Workload) EtcdAutoRecoverAlarms(ctx context.Context, policy EtcdRecoveryPolicy) ([]etcd.MemberAlarm, error) {
+	etcdClient, err := w.anyEtcdClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer etcdClient.Close()
+
+	alarms, err := etcdClient.Alarms(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list etcd alarms using etcd client")
+	}
+
+	members, err := etcdClient.Members(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list etcd members using etcd client")
+	}
+
+	var remaining []etcd.MemberAlarm
+	for _, alarm := range alarms {
+		// CORRUPT alarms are always surfaced, never auto-disarmed.
+		if alarm.Type != pb.AlarmType_NOSPACE || !policy.AutoRecoverNoSpace {
+			remaining = append(remaining, alarm)
+			continue
+		}
+
+		if err := w.recoverNoSpaceAlarm(ctx, members, alarm); err != nil {
+			remaining = append(remaining, alarm)
+		}
+	}
+
+	return remaining, nil
+}
+
+// recoverNoSpaceAlarm defragments the member the alarm was raised on,
+// then disarms the alarm only if free space was actually restored.
+// Defragmenting reclaims disk space, which is usually what triggers etcd
+// to accept the disarm, but it is not guaranteed to free enough: the
+// alarms are re-listed after defragmenting so a NOSPACE alarm that is
+// still active for this member is left in place rather than masked.
+func (w *Workload) recoverNoSpaceAlarm(ctx context.Context, members []*etcd.Member, alarm etcd.MemberAlarm) error {
+	member := etcdMemberForID(members, alarm.MemberID)
+	if member == nil {
+		return errors.Errorf("failed to find etcd member with ID %d", alarm.MemberID)
+	}
+
+	memberClient, err := w.etcdClientGenerator.forNode(ctx, member.Name)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create etcd client for node %q", member.Name)
+	}
+	defer memberClient.Close()
+
+	if err := memberClient.Defragment(ctx); err != nil {
+		return errors.Wrapf(err, "failed to defragment etcd member %q", member.Name)
+	}
+
+	alarmsAfterDefrag, err := memberClient.Alarms(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "failed to re-check alarms for etcd member %q after defragmenting", member.Name)
+	}
+	for _, a := range alarmsAfterDefrag {
+		if a.MemberID == alarm.MemberID && a.Type == pb.AlarmType_NOSPACE {
+			return errors.Errorf("defragmenting etcd member %q did not free enough space to clear its NOSPACE alarm", member.Name)
+		}
+	}
+
+	if err := memberClient.AlarmDisarm(ctx, alarm.MemberID, alarm.Type); err != nil {
+		return errors.Wrapf(err, "failed to disarm NOSPACE alarm for etcd member %q", member.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"go.etcd.io/etcd/clientv3"
+	pb "go.etcd.io/etcd/etcdserver/etcdserverpb"
+	corev1 "k8s.io/api/core/v1"
+
+	"sigs.k8s.io/cluster-api/controlplane/kubeadm/internal/etcd"
+	fake2 "sigs.k8s.io/cluster-api/controlplane/kubeadm/internal/etcd/fake"
+)
+
+func TestWorkload_EtcdAutoRecoverAlarms(t *testing.T) {
+	newWorkload := func(fakeEtcdClient *fake2.FakeEtcdClient) *Workload {
+		return &Workload{
+			Client: &fakeClient{
+				list: &corev1.NodeList{
+					Items: []corev1.Node{
+						nodeNamed("test-1", withProviderID("my-provider-id-1")),
+					},
+				},
+			},
+			etcdClientGenerator: &fakeEtcdClientGenerator{
+				client: &etcd.Client{EtcdClient: fakeEtcdClient},
+			},
+		}
+	}
+
+	t.Run("leaves CORRUPT alarms untouched", func(t *testing.T) {
+		g := NewWithT(t)
+		fakeEtcdClient := &fake2.FakeEtcdClient{
+			MemberListResponse: &clientv3.MemberListResponse{
+				Members: []*pb.Member{{Name: "test-1", ID: uint64(1)}},
+			},
+			AlarmResponse: &clientv3.AlarmResponse{
+				Alarms: []*pb.AlarmMember{{MemberID: uint64(1), Alarm: pb.AlarmType_CORRUPT}},
+			},
+		}
+		w := newWorkload(fakeEtcdClient)
+
+		remaining, err := w.EtcdAutoRecoverAlarms(context.Background(), EtcdRecoveryPolicy{AutoRecoverNoSpace: true})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(remaining).To(ConsistOf(etcd.MemberAlarm{MemberID: uint64(1), Type: pb.AlarmType_CORRUPT}))
+		g.Expect(fakeEtcdClient.DisarmedAlarms).To(BeEmpty())
+		g.Expect(fakeEtcdClient.DefragmentedCount).To(Equal(0))
+	})
+
+	t.Run("leaves NOSPACE alarms untouched when policy opts out", func(t *testing.T) {
+		g := NewWithT(t)
+		fakeEtcdClient := &fake2.FakeEtcdClient{
+			MemberListResponse: &clientv3.MemberListResponse{
+				Members: []*pb.Member{{Name: "test-1", ID: uint64(1)}},
+			},
+			AlarmResponse: &clientv3.AlarmResponse{
+				Alarms: []*pb.AlarmMember{{MemberID: uint64(1), Alarm: pb.AlarmType_NOSPACE}},
+			},
+		}
+		w := newWorkload(fakeEtcdClient)
+
+		remaining, err := w.EtcdAutoRecoverAlarms(context.Background(), EtcdRecoveryPolicy{})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(remaining).To(HaveLen(1))
+		g.Expect(fakeEtcdClient.DisarmedAlarms).To(BeEmpty())
+	})
+
+	t.Run("defragments and disarms a NOSPACE alarm", func(t *testing.T) {
+		g := NewWithT(t)
+		fakeEtcdClient := &fake2.FakeEtcdClient{
+			MemberListResponse: &clientv3.MemberListResponse{
+				Members: []*pb.Member{{Name: "test-1", ID: uint64(1)}},
+			},
+			AlarmResponse: &clientv3.AlarmResponse{
+				Alarms: []*pb.AlarmMember{{MemberID: uint64(1), Alarm: pb.AlarmType_NOSPACE}},
+			},
+		}
+		w := newWorkload(fakeEtcdClient)
+
+		remaining, err := w.EtcdAutoRecoverAlarms(context.Background(), EtcdRecoveryPolicy{AutoRecoverNoSpace: true})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(remaining).To(BeEmpty())
+		g.Expect(fakeEtcdClient.DefragmentedCount).To(Equal(1))
+		g.Expect(fakeEtcdClient.DisarmedAlarms).To(ConsistOf(clientv3.AlarmMember{MemberID: uint64(1), Alarm: pb.AlarmType_NOSPACE}))
+	})
+
+	t.Run("keeps the alarm if defragmenting did not free enough space", func(t *testing.T) {
+		g := NewWithT(t)
+		fakeEtcdClient := &fake2.FakeEtcdClient{
+			MemberListResponse: &clientv3.MemberListResponse{
+				Members: []*pb.Member{{Name: "test-1", ID: uint64(1)}},
+			},
+			AlarmResponse: &clientv3.AlarmResponse{
+				Alarms: []*pb.AlarmMember{{MemberID: uint64(1), Alarm: pb.AlarmType_NOSPACE}},
+			},
+			DefragmentLeavesNoSpaceAlarm: true,
+		}
+		w := newWorkload(fakeEtcdClient)
+
+		remaining, err := w.EtcdAutoRecoverAlarms(context.Background(), EtcdRecoveryPolicy{AutoRecoverNoSpace: true})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(remaining).To(ConsistOf(etcd.MemberAlarm{MemberID: uint64(1), Type: pb.AlarmType_NOSPACE}))
+		g.Expect(fakeEtcdClient.DefragmentedCount).To(Equal(1))
+		g.Expect(fakeEtcdClient.DisarmedAlarms).To(BeEmpty())
+	})
+
+	t.Run("keeps the alarm if disarm fails after defragmenting", func(t *testing.T) {
+		g := NewWithT(t)
+		fakeEtcdClient := &fake2.FakeEtcdClient{
+			MemberListResponse: &clientv3.MemberListResponse{
+				Members: []*pb.Member{{Name: "test-1", ID: uint64(1)}},
+			},
+			AlarmResponse: &clientv3.AlarmResponse{
+				Alarms: []*pb.AlarmMember{{MemberID: uint64(1), Alarm: pb.AlarmType_NOSPACE}},
+			},
+			AlarmDisarmError: errors.New("still over quota"),
+		}
+		w := newWorkload(fakeEtcdClient)
+
+		remaining, err := w.EtcdAutoRecoverAlarms(context.Background(), EtcdRecoveryPolicy{AutoRecoverNoSpace: true})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(remaining).To(ConsistOf(etcd.MemberAlarm{MemberID: uint64(1), Type: pb.AlarmType_NOSPACE}))
+		g.Expect(fakeEtcdClient.DefragmentedCount).To(Equal(1))
+	})
+}
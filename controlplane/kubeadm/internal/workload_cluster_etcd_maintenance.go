@@ -0,0 +1,185 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+
+	"sigs.k8s.io/cluster-api/controlplane/kubeadm/internal/etcd"
+)
+
+const (
+	// DefaultEtcdDefragmentInterval is how often EtcdMaintenanceReconciler
+	// defragments the etcd cluster by default.
+	DefaultEtcdDefragmentInterval = 24 * time.Hour
+	// DefaultEtcdCompactInterval is how often EtcdMaintenanceReconciler
+	// compacts etcd's history by default.
+	DefaultEtcdCompactInterval = time.Hour
+	// DefaultEtcdCompactRetainRevisions is how many revisions of history
+	// are kept by default when compacting.
+	DefaultEtcdCompactRetainRevisions = int64(1000)
+
+	maintenanceJitterFactor = 0.1
+)
+
+// EtcdDefragment defragments every member of the etcd cluster, one
+// member at a time, skipping the current leader until last. It refuses
+// to run if the cluster's quorum is currently at risk.
+func (w *Workload) EtcdDefragment(ctx context.Context) error {
+	status, err := w.EtcdQuorumStatus(ctx)
+	if err != nil {
+		return err
+	}
+	if status.AtRisk {
+		return errors.New("refusing to defragment etcd: quorum is at risk")
+	}
+
+	etcdClient, err := w.anyEtcdClient(ctx)
+	if err != nil {
+		return err
+	}
+	members, err := etcdClient.Members(ctx)
+	etcdClient.Close()
+	if err != nil {
+		return errors.Wrap(err, "failed to list etcd members using etcd client")
+	}
+
+	for _, member := range orderMembersLeaderLast(members) {
+		memberClient, err := w.etcdClientGenerator.forNode(ctx, member.Name)
+		if err != nil {
+			return errors.Wrapf(err, "failed to create etcd client for node %q", member.Name)
+		}
+
+		err = memberClient.Defragment(ctx)
+		memberClient.Close()
+		if err != nil {
+			return errors.Wrapf(err, "failed to defragment etcd member %q", member.Name)
+		}
+	}
+
+	return nil
+}
+
+// orderMembersLeaderLast returns members in their original order, except
+// that the current leader, if any, is moved to the end.
+func orderMembersLeaderLast(members []*etcd.Member) []*etcd.Member {
+	ordered := make([]*etcd.Member, 0, len(members))
+	var leader *etcd.Member
+	for _, member := range members {
+		if member.IsLeader {
+			leader = member
+			continue
+		}
+		ordered = append(ordered, member)
+	}
+	if leader != nil {
+		ordered = append(ordered, leader)
+	}
+	return ordered
+}
+
+// EtcdCompact compacts etcd's history, retaining the most recent
+// retainRevisions revisions. It is a no-op if retainRevisions is not
+// positive, or if the computed compaction revision is not positive.
+func (w *Workload) EtcdCompact(ctx context.Context, retainRevisions int64) error {
+	if retainRevisions <= 0 {
+		return nil
+	}
+
+	etcdClient, err := w.anyEtcdClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer etcdClient.Close()
+
+	status, err := etcdClient.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	compactRev := status.Header.Revision - retainRevisions
+	if compactRev <= 0 {
+		return nil
+	}
+
+	return etcdClient.Compact(ctx, compactRev)
+}
+
+// EtcdMaintenanceReconciler periodically defragments and compacts the
+// workload cluster's etcd cluster, recording the outcome of each run as
+// an event on Owner.
+type EtcdMaintenanceReconciler struct {
+	Workload *Workload
+	Recorder record.EventRecorder
+	Owner    runtime.Object
+
+	// DefragmentInterval and CompactInterval default to
+	// DefaultEtcdDefragmentInterval and DefaultEtcdCompactInterval
+	// respectively when zero.
+	DefragmentInterval time.Duration
+	CompactInterval    time.Duration
+	// CompactRetainRevisions defaults to
+	// DefaultEtcdCompactRetainRevisions when zero.
+	CompactRetainRevisions int64
+}
+
+// Start runs the defragment and compact loops until ctx is cancelled.
+func (r *EtcdMaintenanceReconciler) Start(ctx context.Context) {
+	go r.runDefragment(ctx)
+	go r.runCompact(ctx)
+}
+
+func (r *EtcdMaintenanceReconciler) runDefragment(ctx context.Context) {
+	interval := r.DefragmentInterval
+	if interval <= 0 {
+		interval = DefaultEtcdDefragmentInterval
+	}
+
+	wait.JitterUntilWithContext(ctx, func(ctx context.Context) {
+		if err := r.Workload.EtcdDefragment(ctx); err != nil {
+			r.Recorder.Eventf(r.Owner, corev1.EventTypeWarning, "EtcdDefragmentFailed", "failed to defragment etcd: %v", err)
+			return
+		}
+		r.Recorder.Event(r.Owner, corev1.EventTypeNormal, "EtcdDefragmented", "defragmented all etcd members")
+	}, interval, maintenanceJitterFactor, true)
+}
+
+func (r *EtcdMaintenanceReconciler) runCompact(ctx context.Context) {
+	interval := r.CompactInterval
+	if interval <= 0 {
+		interval = DefaultEtcdCompactInterval
+	}
+	retainRevisions := r.CompactRetainRevisions
+	if retainRevisions <= 0 {
+		retainRevisions = DefaultEtcdCompactRetainRevisions
+	}
+
+	wait.JitterUntilWithContext(ctx, func(ctx context.Context) {
+		if err := r.Workload.EtcdCompact(ctx, retainRevisions); err != nil {
+			r.Recorder.Eventf(r.Owner, corev1.EventTypeWarning, "EtcdCompactFailed", "failed to compact etcd: %v", err)
+			return
+		}
+		r.Recorder.Event(r.Owner, corev1.EventTypeNormal, "EtcdCompacted", "compacted etcd history")
+	}, interval, maintenanceJitterFactor, true)
+}
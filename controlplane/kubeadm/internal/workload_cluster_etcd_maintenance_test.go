@@ -0,0 +1,173 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"go.etcd.io/etcd/clientv3"
+	pb "go.etcd.io/etcd/etcdserver/etcdserverpb"
+	corev1 "k8s.io/api/core/v1"
+
+	"sigs.k8s.io/cluster-api/controlplane/kubeadm/internal/etcd"
+	fake2 "sigs.k8s.io/cluster-api/controlplane/kubeadm/internal/etcd/fake"
+)
+
+func TestWorkload_EtcdDefragment(t *testing.T) {
+	newWorkload := func(fakeEtcdClient *fake2.FakeEtcdClient) *Workload {
+		return &Workload{
+			Client: &fakeClient{
+				get: map[string]interface{}{
+					"kube-system/etcd-test-1": etcdPod("etcd-test-1", withReadyOption),
+					"kube-system/etcd-test-2": etcdPod("etcd-test-2", withReadyOption),
+					"kube-system/etcd-test-3": etcdPod("etcd-test-3", withReadyOption),
+				},
+				list: &corev1.NodeList{
+					Items: []corev1.Node{
+						nodeNamed("test-1", withProviderID("my-provider-id-1")),
+					},
+				},
+			},
+			etcdClientGenerator: &fakeEtcdClientGenerator{
+				client: &etcd.Client{
+					EtcdClient: fakeEtcdClient,
+					LeaderID:   uint64(2),
+				},
+			},
+		}
+	}
+
+	t.Run("defragments every member, leader last", func(t *testing.T) {
+		g := NewWithT(t)
+		var order []string
+		test1Client := &fake2.FakeEtcdClient{
+			Name:          "test-1",
+			DefragmentLog: &order,
+			MemberListResponse: &clientv3.MemberListResponse{
+				Members: []*pb.Member{
+					{Name: "test-1", ID: uint64(1)},
+					{Name: "test-2", ID: uint64(2)},
+					{Name: "test-3", ID: uint64(3)},
+				},
+			},
+		}
+		test2Client := &fake2.FakeEtcdClient{Name: "test-2", DefragmentLog: &order}
+		test3Client := &fake2.FakeEtcdClient{Name: "test-3", DefragmentLog: &order}
+
+		w := &Workload{
+			Client: &fakeClient{
+				get: map[string]interface{}{
+					"kube-system/etcd-test-1": etcdPod("etcd-test-1", withReadyOption),
+					"kube-system/etcd-test-2": etcdPod("etcd-test-2", withReadyOption),
+					"kube-system/etcd-test-3": etcdPod("etcd-test-3", withReadyOption),
+				},
+				list: &corev1.NodeList{
+					Items: []corev1.Node{
+						nodeNamed("test-1", withProviderID("my-provider-id-1")),
+					},
+				},
+			},
+			etcdClientGenerator: &fakeEtcdClientGenerator{
+				clients: map[string]*etcd.Client{
+					"test-1": {EtcdClient: test1Client, LeaderID: uint64(2)},
+					"test-2": {EtcdClient: test2Client, LeaderID: uint64(2)},
+					"test-3": {EtcdClient: test3Client, LeaderID: uint64(2)},
+				},
+			},
+		}
+
+		g.Expect(w.EtcdDefragment(context.Background())).To(Succeed())
+		g.Expect(order).To(Equal([]string{"test-1", "test-3", "test-2"}))
+	})
+
+	t.Run("refuses to run when quorum is at risk", func(t *testing.T) {
+		g := NewWithT(t)
+		fakeEtcdClient := &fake2.FakeEtcdClient{
+			MemberListResponse: &clientv3.MemberListResponse{
+				Members: []*pb.Member{
+					{Name: "test-1", ID: uint64(1)},
+					{Name: "test-2", ID: uint64(2)},
+				},
+			},
+		}
+		w := newWorkload(fakeEtcdClient)
+		w.Client = &fakeClient{
+			get: map[string]interface{}{
+				"kube-system/etcd-test-1": etcdPod("etcd-test-1"),
+				"kube-system/etcd-test-2": etcdPod("etcd-test-2"),
+			},
+			list: &corev1.NodeList{
+				Items: []corev1.Node{
+					nodeNamed("test-1", withProviderID("my-provider-id-1")),
+				},
+			},
+		}
+
+		g.Expect(w.EtcdDefragment(context.Background())).NotTo(Succeed())
+		g.Expect(fakeEtcdClient.DefragmentedCount).To(Equal(0))
+	})
+}
+
+func TestWorkload_EtcdCompact(t *testing.T) {
+	newWorkload := func(fakeEtcdClient *fake2.FakeEtcdClient) *Workload {
+		return &Workload{
+			Client: &fakeClient{
+				list: &corev1.NodeList{
+					Items: []corev1.Node{
+						nodeNamed("test-1", withProviderID("my-provider-id-1")),
+					},
+				},
+			},
+			etcdClientGenerator: &fakeEtcdClientGenerator{
+				client: &etcd.Client{EtcdClient: fakeEtcdClient},
+			},
+		}
+	}
+
+	t.Run("compacts up to currentRevision minus retainRevisions", func(t *testing.T) {
+		g := NewWithT(t)
+		fakeEtcdClient := &fake2.FakeEtcdClient{
+			StatusResponse: &clientv3.StatusResponse{Header: &pb.ResponseHeader{Revision: 1500}},
+		}
+		w := newWorkload(fakeEtcdClient)
+		g.Expect(w.EtcdCompact(context.Background(), 1000)).To(Succeed())
+		g.Expect(fakeEtcdClient.CompactedRevision).To(BeEquivalentTo(500))
+	})
+
+	t.Run("is a no-op if retainRevisions exceeds the current revision", func(t *testing.T) {
+		g := NewWithT(t)
+		fakeEtcdClient := &fake2.FakeEtcdClient{
+			StatusResponse: &clientv3.StatusResponse{Header: &pb.ResponseHeader{Revision: 500}},
+		}
+		w := newWorkload(fakeEtcdClient)
+		g.Expect(w.EtcdCompact(context.Background(), 1000)).To(Succeed())
+		g.Expect(fakeEtcdClient.CompactedRevision).To(BeEquivalentTo(0))
+	})
+
+	t.Run("is a no-op if retainRevisions is not positive", func(t *testing.T) {
+		g := NewWithT(t)
+		fakeEtcdClient := &fake2.FakeEtcdClient{
+			StatusResponse: &clientv3.StatusResponse{Header: &pb.ResponseHeader{Revision: 1500}},
+		}
+		w := newWorkload(fakeEtcdClient)
+		g.Expect(w.EtcdCompact(context.Background(), 0)).To(Succeed())
+		g.Expect(fakeEtcdClient.CompactedRevision).To(BeEquivalentTo(0))
+	})
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sigs.k8s.io/cluster-api/controlplane/kubeadm/internal/etcd"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ErrQuorumViolation is returned by EtcdQuorumGuard when an operation
+// would drop the etcd cluster's healthy-member count below quorum.
+var ErrQuorumViolation = errors.New("etcd quorum would be violated by this operation")
+
+// EtcdQuorumStatus summarizes the health of an etcd cluster relative to
+// the quorum it needs to keep making progress.
+type EtcdQuorumStatus struct {
+	Members int
+	Healthy int
+	Quorum  int
+	AtRisk  bool
+}
+
+// EtcdQuorumGuard blocks control plane operations, such as removing an
+// etcd member or moving leadership to it, that would leave the etcd
+// cluster without quorum.
+type EtcdQuorumGuard struct {
+	workload *Workload
+}
+
+// NewEtcdQuorumGuard returns a quorum guard backed by the given Workload.
+func NewEtcdQuorumGuard(w *Workload) *EtcdQuorumGuard {
+	return &EtcdQuorumGuard{workload: w}
+}
+
+// AllowRemoval returns ErrQuorumViolation if removing the member
+// identified by candidateID would drop the cluster's healthy-member
+// count below quorum.
+func (g *EtcdQuorumGuard) AllowRemoval(ctx context.Context, etcdClient *etcd.Client, candidateID uint64) error {
+	status, err := g.workload.etcdQuorumStatus(ctx, etcdClient)
+	if err != nil {
+		return err
+	}
+
+	members, err := etcdClient.Members(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to list etcd members using etcd client")
+	}
+
+	healthyAfterRemoval := status.Healthy
+	for _, member := range members {
+		if member.ID == candidateID && g.workload.etcdMemberPodIsReady(ctx, member.Name) {
+			healthyAfterRemoval--
+		}
+	}
+
+	if healthyAfterRemoval < status.Quorum {
+		return ErrQuorumViolation
+	}
+	return nil
+}
+
+// AllowLeadershipMove returns ErrQuorumViolation if the candidate's
+// static pod is not Ready, since moving leadership to an unhealthy
+// member risks leaving the cluster without a leader.
+func (g *EtcdQuorumGuard) AllowLeadershipMove(ctx context.Context, candidate *etcd.Member) error {
+	if !g.workload.etcdMemberPodIsReady(ctx, candidate.Name) {
+		return ErrQuorumViolation
+	}
+	return nil
+}
+
+// EtcdQuorumStatus reports the etcd cluster's quorum status, using any
+// reachable control plane node to talk to etcd.
+func (w *Workload) EtcdQuorumStatus(ctx context.Context) (EtcdQuorumStatus, error) {
+	etcdClient, err := w.anyEtcdClient(ctx)
+	if err != nil {
+		return EtcdQuorumStatus{}, err
+	}
+	defer etcdClient.Close()
+
+	return w.etcdQuorumStatus(ctx, etcdClient)
+}
+
+func (w *Workload) etcdQuorumStatus(ctx context.Context, etcdClient *etcd.Client) (EtcdQuorumStatus, error) {
+	members, err := etcdClient.Members(ctx)
+	if err != nil {
+		return EtcdQuorumStatus{}, errors.Wrap(err, "failed to list etcd members using etcd client")
+	}
+
+	healthy := 0
+	for _, member := range members {
+		if w.etcdMemberPodIsReady(ctx, member.Name) {
+			healthy++
+		}
+	}
+
+	quorum := len(members)/2 + 1
+	return EtcdQuorumStatus{
+		Members: len(members),
+		Healthy: healthy,
+		Quorum:  quorum,
+		AtRisk:  healthy < quorum,
+	}, nil
+}
+
+// anyEtcdClient returns an etcd client for the first control plane node
+// that can be reached, for operations that are not scoped to a specific
+// node.
+func (w *Workload) anyEtcdClient(ctx context.Context) (*etcd.Client, error) {
+	controlPlaneNodes, err := w.getControlPlaneNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, node := range controlPlaneNodes.Items {
+		etcdClient, err := w.etcdClientGenerator.forNode(ctx, node.Name)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return etcdClient, nil
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("no control plane nodes available to create an etcd client")
+	}
+	return nil, lastErr
+}
+
+// etcdMemberPodIsReady reports whether the etcd static pod backing the
+// given member name is Ready. When readiness cannot be determined (the
+// Workload has no Client configured, the member has not joined yet, or
+// its pod cannot be found) this defaults to false, since the guard this
+// feeds must not count an unconfirmed member as healthy.
+func (w *Workload) etcdMemberPodIsReady(ctx context.Context, name string) bool {
+	if w.Client == nil || name == "" {
+		return false
+	}
+
+	pod := &corev1.Pod{}
+	podKey := ctrlclient.ObjectKey{Name: staticPodName("etcd", name), Namespace: metav1.NamespaceSystem}
+	if err := w.Client.Get(ctx, podKey, pod); err != nil {
+		return false
+	}
+	return podIsReady(pod)
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"sigs.k8s.io/cluster-api/controlplane/kubeadm/internal/etcd"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// etcdSnapshotHostPathImage is the image used by the Job created to take
+// an on-node hostPath snapshot when EtcdSnapshotOptions.HostPath is set.
+const etcdSnapshotHostPathImage = "k8s.gcr.io/etcdadm/etcd-manager:latest"
+
+const (
+	// etcdSnapshotJobPollInterval is how often the HostPath sink polls
+	// its Job for completion.
+	etcdSnapshotJobPollInterval = 5 * time.Second
+	// etcdSnapshotJobTimeout bounds how long the HostPath sink waits for
+	// its Job to complete before giving up.
+	etcdSnapshotJobTimeout = 5 * time.Minute
+	// etcdSnapshotJobTTLSecondsAfterFinished is how long a finished etcd
+	// snapshot Job, and its Pod, are left around before the workload
+	// cluster garbage collects them.
+	etcdSnapshotJobTTLSecondsAfterFinished = int32(3600)
+)
+
+// EtcdSnapshotOptions configures where Workload.EtcdSnapshot writes the
+// snapshot it takes. Exactly one of Writer or HostPath must be set.
+type EtcdSnapshotOptions struct {
+	// Writer, if set, receives the raw snapshot bytes streamed directly
+	// from etcd. This is the preferred option for callers that want to
+	// inspect or further ship the snapshot themselves.
+	Writer io.Writer
+
+	// HostPath, if set, is a directory on the node running the etcd
+	// leader that a Job writes the snapshot into, as a file named after
+	// the Job's creation time in Unix nanoseconds. This is an initial
+	// implementation intended for operators who want snapshots kept on
+	// the control plane nodes themselves; EtcdSnapshot waits for the Job
+	// to finish (up to etcdSnapshotJobTimeout) and fails if it does not
+	// complete successfully.
+	HostPath string
+}
+
+// EtcdSnapshot describes a snapshot taken by Workload.EtcdSnapshot.
+type EtcdSnapshot struct {
+	// Path is the HostPath directory the snapshot was written into, or
+	// empty when a Writer sink was used. The snapshot's file name within
+	// that directory is not recorded.
+	Path string
+	// Revision is the etcd revision the snapshot was taken at.
+	Revision int64
+	// SHA256 is the hex-encoded SHA256 checksum of the snapshot bytes.
+	// It is left empty for the HostPath sink, since the bytes never
+	// pass through this process.
+	SHA256 string
+}
+
+// EtcdSnapshot takes a point-in-time snapshot of the etcd cluster's
+// leader and writes it to the sink configured in opts.
+func (w *Workload) EtcdSnapshot(ctx context.Context, opts EtcdSnapshotOptions) (*EtcdSnapshot, error) {
+	if (opts.Writer == nil) == (opts.HostPath == "") {
+		return nil, errors.New("exactly one of EtcdSnapshotOptions.Writer or HostPath must be set")
+	}
+
+	etcdClient, err := w.anyEtcdClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer etcdClient.Close()
+
+	members, err := etcdClient.Members(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list etcd members using etcd client")
+	}
+	leader := etcdLeader(members)
+	if leader == nil {
+		return nil, errors.New("failed to determine etcd leader")
+	}
+
+	leaderClient, err := w.etcdClientGenerator.forNode(ctx, leader.Name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create etcd client for leader node %q", leader.Name)
+	}
+	defer leaderClient.Close()
+
+	status, err := leaderClient.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.HostPath != "" {
+		job, err := w.createEtcdSnapshotJob(ctx, leader.Name, opts.HostPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := w.waitForEtcdSnapshotJob(ctx, job); err != nil {
+			return nil, errors.Wrapf(err, "etcd snapshot job %q did not complete successfully", job.Name)
+		}
+		return &EtcdSnapshot{Path: opts.HostPath, Revision: status.Header.Revision}, nil
+	}
+
+	hash := sha256.New()
+	if err := leaderClient.Snapshot(ctx, io.MultiWriter(opts.Writer, hash)); err != nil {
+		return nil, err
+	}
+
+	return &EtcdSnapshot{
+		Revision: status.Header.Revision,
+		SHA256:   fmt.Sprintf("%x", hash.Sum(nil)),
+	}, nil
+}
+
+// etcdLeader returns the member marked as leader, if any.
+func etcdLeader(members []*etcd.Member) *etcd.Member {
+	for _, member := range members {
+		if member.IsLeader {
+			return member
+		}
+	}
+	return nil
+}
+
+// createEtcdSnapshotJob creates a Job on the workload cluster that writes
+// an etcd snapshot to hostPath on nodeName, and returns the created Job.
+func (w *Workload) createEtcdSnapshotJob(ctx context.Context, nodeName, hostPath string) (*batchv1.Job, error) {
+	backoffLimit := int32(1)
+	ttlSecondsAfterFinished := etcdSnapshotJobTTLSecondsAfterFinished
+	hostPathType := corev1.HostPathDirectoryOrCreate
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "etcd-snapshot-",
+			Namespace:    metav1.NamespaceSystem,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoffLimit,
+			TTLSecondsAfterFinished: &ttlSecondsAfterFinished,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					NodeName:      nodeName,
+					HostNetwork:   true,
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{
+						{
+							Name:    "etcd-snapshot",
+							Image:   etcdSnapshotHostPathImage,
+							Command: []string{"etcdctl", "snapshot", "save", fmt.Sprintf("/snapshot/%d.db", time.Now().UnixNano())},
+							VolumeMounts: []corev1.VolumeMount{
+								{Name: "snapshot", MountPath: "/snapshot"},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "snapshot",
+							VolumeSource: corev1.VolumeSource{
+								HostPath: &corev1.HostPathVolumeSource{
+									Path: hostPath,
+									Type: &hostPathType,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if err := w.Client.Create(ctx, job); err != nil {
+		return nil, errors.Wrapf(err, "failed to create etcd snapshot job for node %q", nodeName)
+	}
+	return job, nil
+}
+
+// waitForEtcdSnapshotJob polls job until it reports completion, failure,
+// or etcdSnapshotJobTimeout elapses, whichever comes first.
+func (w *Workload) waitForEtcdSnapshotJob(ctx context.Context, job *batchv1.Job) error {
+	key := ctrlclient.ObjectKey{Name: job.Name, Namespace: job.Namespace}
+	return wait.PollImmediate(etcdSnapshotJobPollInterval, etcdSnapshotJobTimeout, func() (bool, error) {
+		current := &batchv1.Job{}
+		if err := w.Client.Get(ctx, key, current); err != nil {
+			return false, errors.Wrapf(err, "failed to get etcd snapshot job %q", job.Name)
+		}
+		if jobHasCondition(current, batchv1.JobFailed) {
+			return false, errors.Errorf("etcd snapshot job %q failed", job.Name)
+		}
+		return jobHasCondition(current, batchv1.JobComplete), nil
+	})
+}
+
+// jobHasCondition reports whether job has conditionType set to True.
+func jobHasCondition(job *batchv1.Job, conditionType batchv1.JobConditionType) bool {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == conditionType {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
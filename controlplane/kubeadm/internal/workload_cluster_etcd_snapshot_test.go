@@ -0,0 +1,243 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"go.etcd.io/etcd/clientv3"
+	pb "go.etcd.io/etcd/etcdserver/etcdserverpb"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	"sigs.k8s.io/cluster-api/controlplane/kubeadm/internal/etcd"
+	fake2 "sigs.k8s.io/cluster-api/controlplane/kubeadm/internal/etcd/fake"
+)
+
+func TestWorkload_EtcdSnapshot(t *testing.T) {
+	newWorkload := func(fakeEtcdClient *fake2.FakeEtcdClient) *Workload {
+		return &Workload{
+			Client: &fakeClient{
+				list: &corev1.NodeList{
+					Items: []corev1.Node{
+						nodeNamed("test-1", withProviderID("my-provider-id-1")),
+					},
+				},
+			},
+			etcdClientGenerator: &fakeEtcdClientGenerator{
+				client: &etcd.Client{
+					EtcdClient: fakeEtcdClient,
+					LeaderID:   uint64(2),
+				},
+			},
+		}
+	}
+
+	t.Run("returns error if neither Writer nor HostPath is set", func(t *testing.T) {
+		g := NewWithT(t)
+		w := newWorkload(&fake2.FakeEtcdClient{})
+		_, err := w.EtcdSnapshot(context.Background(), EtcdSnapshotOptions{})
+		g.Expect(err).To(HaveOccurred())
+	})
+
+	t.Run("streams the snapshot to the given Writer and reports its checksum", func(t *testing.T) {
+		g := NewWithT(t)
+		snapshotContents := []byte("this is not a real etcd snapshot")
+		fakeEtcdClient := &fake2.FakeEtcdClient{
+			SnapshotResponse: snapshotContents,
+			MemberListResponse: &clientv3.MemberListResponse{
+				Members: []*pb.Member{
+					{Name: "test-1", ID: uint64(1)},
+					{Name: "test-2", ID: uint64(2)},
+				},
+			},
+			StatusResponse: &clientv3.StatusResponse{Header: &pb.ResponseHeader{Revision: 42}},
+		}
+		w := newWorkload(fakeEtcdClient)
+
+		var out bytes.Buffer
+		snapshot, err := w.EtcdSnapshot(context.Background(), EtcdSnapshotOptions{Writer: &out})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(out.Bytes()).To(Equal(snapshotContents))
+		g.Expect(snapshot.Revision).To(BeEquivalentTo(42))
+		g.Expect(snapshot.SHA256).To(Equal(fmt.Sprintf("%x", sha256.Sum256(snapshotContents))))
+	})
+
+	t.Run("returns error if the snapshot stream fails", func(t *testing.T) {
+		g := NewWithT(t)
+		fakeEtcdClient := &fake2.FakeEtcdClient{
+			SnapshotError: errors.New("stream broke"),
+			MemberListResponse: &clientv3.MemberListResponse{
+				Members: []*pb.Member{
+					{Name: "test-1", ID: uint64(1)},
+					{Name: "test-2", ID: uint64(2)},
+				},
+			},
+			StatusResponse: &clientv3.StatusResponse{Header: &pb.ResponseHeader{Revision: 42}},
+		}
+		w := newWorkload(fakeEtcdClient)
+
+		var out bytes.Buffer
+		_, err := w.EtcdSnapshot(context.Background(), EtcdSnapshotOptions{Writer: &out})
+		g.Expect(err).To(HaveOccurred())
+	})
+
+	newHostPathWorkload := func(client *fakeClient) *Workload {
+		return &Workload{
+			Client: client,
+			etcdClientGenerator: &fakeEtcdClientGenerator{
+				client: &etcd.Client{
+					EtcdClient: &fake2.FakeEtcdClient{
+						MemberListResponse: &clientv3.MemberListResponse{
+							Members: []*pb.Member{
+								{Name: "test-1", ID: uint64(1)},
+								{Name: "test-2", ID: uint64(2)},
+							},
+						},
+						StatusResponse: &clientv3.StatusResponse{Header: &pb.ResponseHeader{Revision: 42}},
+					},
+					LeaderID: uint64(2),
+				},
+			},
+		}
+	}
+
+	t.Run("creates a Job for the HostPath sink and waits for it to complete", func(t *testing.T) {
+		g := NewWithT(t)
+		client := &fakeClient{
+			list: &corev1.NodeList{
+				Items: []corev1.Node{
+					nodeNamed("test-1", withProviderID("my-provider-id-1")),
+				},
+			},
+			jobConditions: []batchv1.JobCondition{
+				{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+			},
+		}
+		w := newHostPathWorkload(client)
+
+		snapshot, err := w.EtcdSnapshot(context.Background(), EtcdSnapshotOptions{HostPath: "/var/lib/etcd-snapshots"})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(snapshot.Path).To(Equal("/var/lib/etcd-snapshots"))
+		g.Expect(snapshot.Revision).To(BeEquivalentTo(42))
+		g.Expect(snapshot.SHA256).To(BeEmpty())
+
+		g.Expect(client.created).To(HaveLen(1))
+		job, ok := client.created[0].(*batchv1.Job)
+		g.Expect(ok).To(BeTrue())
+		g.Expect(job.Spec.Template.Spec.NodeName).To(Equal("test-2"))
+		g.Expect(job.Spec.TTLSecondsAfterFinished).NotTo(BeNil())
+	})
+
+	t.Run("returns error if the HostPath sink's Job fails", func(t *testing.T) {
+		g := NewWithT(t)
+		client := &fakeClient{
+			list: &corev1.NodeList{
+				Items: []corev1.Node{
+					nodeNamed("test-1", withProviderID("my-provider-id-1")),
+				},
+			},
+			jobConditions: []batchv1.JobCondition{
+				{Type: batchv1.JobFailed, Status: corev1.ConditionTrue},
+			},
+		}
+		w := newHostPathWorkload(client)
+
+		_, err := w.EtcdSnapshot(context.Background(), EtcdSnapshotOptions{HostPath: "/var/lib/etcd-snapshots"})
+		g.Expect(err).To(HaveOccurred())
+	})
+}
+
+func TestRemoveEtcdMemberForMachine_SnapshotBeforeRemove(t *testing.T) {
+	machine := &clusterv1.Machine{
+		Status: clusterv1.MachineStatus{
+			NodeRef: &corev1.ObjectReference{
+				Name: "cp1",
+			},
+		},
+	}
+	cp1 := nodeNamed("cp1")
+	cp1.Labels = map[string]string{labelNodeRoleMaster: ""}
+	cp1.Status.Addresses = []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}}
+	cp2 := nodeNamed("cp2")
+	cp2.Labels = map[string]string{labelNodeRoleMaster: ""}
+	cp2.Status.Addresses = []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.2"}}
+
+	newWorkload := func(fakeEtcdClient *fake2.FakeEtcdClient) *Workload {
+		return &Workload{
+			Client: &fakeClient{
+				get: map[string]interface{}{
+					"kube-system/etcd-cp1": etcdPod("etcd-cp1", withReadyOption),
+					"kube-system/etcd-cp2": etcdPod("etcd-cp2", withReadyOption),
+					"kube-system/etcd-cp3": etcdPod("etcd-cp3", withReadyOption),
+				},
+				list: &corev1.NodeList{Items: []corev1.Node{cp1, cp2}},
+			},
+			etcdClientGenerator: &fakeEtcdClientGenerator{
+				client: &etcd.Client{
+					EtcdClient: fakeEtcdClient,
+					LeaderID:   uint64(2),
+				},
+			},
+		}
+	}
+
+	members := &clientv3.MemberListResponse{
+		Members: []*pb.Member{
+			{Name: "cp1", ID: uint64(1), PeerURLs: []string{"https://10.0.0.1:2380"}},
+			{Name: "cp2", ID: uint64(2), PeerURLs: []string{"https://10.0.0.2:2380"}},
+			{Name: "cp3", ID: uint64(3), PeerURLs: []string{"https://10.0.0.3:2380"}},
+		},
+	}
+
+	t.Run("aborts the removal if the snapshot fails", func(t *testing.T) {
+		g := NewWithT(t)
+		fakeEtcdClient := &fake2.FakeEtcdClient{
+			MemberListResponse: members,
+			SnapshotError:      errors.New("disk full"),
+		}
+		w := newWorkload(fakeEtcdClient)
+
+		var out bytes.Buffer
+		err := w.RemoveEtcdMemberForMachine(context.Background(), machine,
+			WithEtcdSnapshotBeforeRemove(EtcdSnapshotOptions{Writer: &out}))
+		g.Expect(err).To(HaveOccurred())
+	})
+
+	t.Run("removes the member after a successful snapshot", func(t *testing.T) {
+		g := NewWithT(t)
+		fakeEtcdClient := &fake2.FakeEtcdClient{
+			MemberListResponse: members,
+			SnapshotResponse:   []byte("snapshot-bytes"),
+			StatusResponse:     &clientv3.StatusResponse{Header: &pb.ResponseHeader{Revision: 7}},
+		}
+		w := newWorkload(fakeEtcdClient)
+
+		var out bytes.Buffer
+		err := w.RemoveEtcdMemberForMachine(context.Background(), machine,
+			WithEtcdSnapshotBeforeRemove(EtcdSnapshotOptions{Writer: &out}))
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(out.Len()).To(BeNumerically(">", 0))
+	})
+}
@@ -77,9 +77,106 @@ func TestWorkload_EtcdIsHealthy(t *testing.T) {
 	health, err := workload.EtcdIsHealthy(ctx)
 	g.Expect(err).NotTo(HaveOccurred())
 
-	for _, err := range health {
-		g.Expect(err).NotTo(HaveOccurred())
+	for _, report := range health {
+		g.Expect(report.Err).NotTo(HaveOccurred())
+		g.Expect(report.Alarms).To(BeEmpty())
+	}
+}
+
+func TestWorkload_EtcdIsHealthy_CategorizesAlarmsByMember(t *testing.T) {
+	g := NewWithT(t)
+
+	workload := &Workload{
+		Client: &fakeClient{
+			get: map[string]interface{}{
+				"kube-system/etcd-test-1": etcdPod("etcd-test-1", withReadyOption),
+				"kube-system/etcd-test-2": etcdPod("etcd-test-2", withReadyOption),
+			},
+			list: &corev1.NodeList{
+				Items: []corev1.Node{
+					nodeNamed("test-1", withProviderID("my-provider-id-1")),
+					nodeNamed("test-2", withProviderID("my-provider-id-2")),
+				},
+			},
+		},
+		etcdClientGenerator: &fakeEtcdClientGenerator{
+			client: &etcd.Client{
+				EtcdClient: &fake2.FakeEtcdClient{
+					MemberListResponse: &clientv3.MemberListResponse{
+						Members: []*pb.Member{
+							{Name: "test-1", ID: uint64(1)},
+							{Name: "test-2", ID: uint64(2)},
+						},
+					},
+					AlarmResponse: &clientv3.AlarmResponse{
+						Alarms: []*pb.AlarmMember{
+							{MemberID: uint64(1), Alarm: pb.AlarmType_NOSPACE},
+							{MemberID: uint64(2), Alarm: pb.AlarmType_CORRUPT},
+						},
+					},
+				},
+			},
+		},
 	}
+
+	health, err := workload.EtcdIsHealthy(context.Background())
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(health["test-1"].Alarms).To(ConsistOf(pb.AlarmType_NOSPACE))
+	g.Expect(health["test-1"].HasAlarmType(pb.AlarmType_CORRUPT)).To(BeFalse())
+	g.Expect(health["test-2"].Alarms).To(ConsistOf(pb.AlarmType_CORRUPT))
+	g.Expect(health["test-2"].HasAlarmType(pb.AlarmType_CORRUPT)).To(BeTrue())
+}
+
+func TestWorkload_EtcdClusterHealthyForRollingUpdate(t *testing.T) {
+	newWorkload := func(alarms []*pb.AlarmMember) *Workload {
+		return &Workload{
+			Client: &fakeClient{
+				get: map[string]interface{}{
+					"kube-system/etcd-test-1": etcdPod("etcd-test-1", withReadyOption),
+				},
+				list: &corev1.NodeList{
+					Items: []corev1.Node{
+						nodeNamed("test-1", withProviderID("my-provider-id-1")),
+					},
+				},
+			},
+			etcdClientGenerator: &fakeEtcdClientGenerator{
+				client: &etcd.Client{
+					EtcdClient: &fake2.FakeEtcdClient{
+						MemberListResponse: &clientv3.MemberListResponse{
+							Members: []*pb.Member{{Name: "test-1", ID: uint64(1)}},
+						},
+						AlarmResponse: &clientv3.AlarmResponse{Alarms: alarms},
+					},
+				},
+			},
+		}
+	}
+
+	t.Run("is healthy with no alarms", func(t *testing.T) {
+		g := NewWithT(t)
+		w := newWorkload(nil)
+		ok, err := w.EtcdClusterHealthyForRollingUpdate(context.Background())
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(ok).To(BeTrue())
+	})
+
+	t.Run("is healthy with a NOSPACE alarm", func(t *testing.T) {
+		g := NewWithT(t)
+		w := newWorkload([]*pb.AlarmMember{{MemberID: uint64(1), Alarm: pb.AlarmType_NOSPACE}})
+		ok, err := w.EtcdClusterHealthyForRollingUpdate(context.Background())
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(ok).To(BeTrue())
+	})
+
+	t.Run("refuses rolling update with a CORRUPT alarm", func(t *testing.T) {
+		g := NewWithT(t)
+		w := newWorkload([]*pb.AlarmMember{{MemberID: uint64(1), Alarm: pb.AlarmType_CORRUPT}})
+		ok, err := w.EtcdClusterHealthyForRollingUpdate(context.Background())
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(ok).To(BeFalse())
+	})
 }
 
 func TestUpdateEtcdVersionInKubeadmConfigMap(t *testing.T) {
@@ -185,6 +282,11 @@ func TestRemoveEtcdMemberFromMachine(t *testing.T) {
 				labelNodeRoleMaster: "",
 			},
 		},
+		Status: corev1.NodeStatus{
+			Addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+			},
+		},
 	}
 	cp1DiffNS := cp1.DeepCopy()
 	cp1DiffNS.Namespace = "diff-ns"
@@ -192,6 +294,7 @@ func TestRemoveEtcdMemberFromMachine(t *testing.T) {
 	cp2 := cp1.DeepCopy()
 	cp2.Name = "cp2"
 	cp2.Namespace = "cp2"
+	cp2.Status.Addresses[0].Address = "10.0.0.2"
 
 	g := NewWithT(t)
 	scheme := runtime.NewScheme()
@@ -222,6 +325,12 @@ func TestRemoveEtcdMemberFromMachine(t *testing.T) {
 			objs:      []runtime.Object{cp1},
 			expectErr: true,
 		},
+		{
+			name:      "is a no-op if the machine's node is already gone",
+			machine:   machine,
+			objs:      []runtime.Object{cp2},
+			expectErr: false,
+		},
 		{
 			name: "returns error if nodes match node ref name",
 			machine: &clusterv1.Machine{
@@ -278,7 +387,51 @@ func TestRemoveEtcdMemberFromMachine(t *testing.T) {
 			expectErr: true,
 		},
 		{
-			name:    "removes member from etcd",
+			name:    "removes member from etcd, matching by peer URL",
+			machine: machine,
+			objs:    []runtime.Object{cp1, cp2, etcdPod("etcd-cp1", withReadyOption), etcdPod("etcd-cp2", withReadyOption), etcdPod("etcd-cp3", withReadyOption)},
+			etcdClientGenerator: &fakeEtcdClientGenerator{
+				client: &etcd.Client{
+					EtcdClient: &fake2.FakeEtcdClient{
+						MemberListResponse: &clientv3.MemberListResponse{
+							Members: []*pb.Member{
+								{Name: "cp1", ID: uint64(1), PeerURLs: []string{"https://10.0.0.1:2380"}},
+								{Name: "cp2", ID: uint64(2), PeerURLs: []string{"https://10.0.0.2:2380"}},
+								{Name: "cp3", ID: uint64(3), PeerURLs: []string{"https://10.0.0.3:2380"}},
+							},
+						},
+						AlarmResponse: &clientv3.AlarmResponse{
+							Alarms: []*pb.AlarmMember{},
+						},
+					},
+				},
+			},
+			expectErr: false,
+		},
+		{
+			name:    "removes member from etcd, matching by peer URL with an empty Name (not yet joined)",
+			machine: machine,
+			objs:    []runtime.Object{cp1, cp2, etcdPod("etcd-cp2", withReadyOption), etcdPod("etcd-cp3", withReadyOption)},
+			etcdClientGenerator: &fakeEtcdClientGenerator{
+				client: &etcd.Client{
+					EtcdClient: &fake2.FakeEtcdClient{
+						MemberListResponse: &clientv3.MemberListResponse{
+							Members: []*pb.Member{
+								{Name: "", ID: uint64(1), PeerURLs: []string{"https://10.0.0.1:2380"}},
+								{Name: "cp2", ID: uint64(2), PeerURLs: []string{"https://10.0.0.2:2380"}},
+								{Name: "cp3", ID: uint64(3), PeerURLs: []string{"https://10.0.0.3:2380"}},
+							},
+						},
+						AlarmResponse: &clientv3.AlarmResponse{
+							Alarms: []*pb.AlarmMember{},
+						},
+					},
+				},
+			},
+			expectErr: false,
+		},
+		{
+			name:    "is a no-op if the etcd member was already removed",
 			machine: machine,
 			objs:    []runtime.Object{cp1, cp2},
 			etcdClientGenerator: &fakeEtcdClientGenerator{
@@ -286,9 +439,30 @@ func TestRemoveEtcdMemberFromMachine(t *testing.T) {
 					EtcdClient: &fake2.FakeEtcdClient{
 						MemberListResponse: &clientv3.MemberListResponse{
 							Members: []*pb.Member{
-								{Name: "cp1", ID: uint64(1)},
-								{Name: "test-2", ID: uint64(2)},
-								{Name: "test-3", ID: uint64(3)},
+								{Name: "cp2", ID: uint64(2), PeerURLs: []string{"https://10.0.0.2:2380"}},
+							},
+						},
+						AlarmResponse: &clientv3.AlarmResponse{
+							Alarms: []*pb.AlarmMember{},
+						},
+					},
+				},
+			},
+			expectErr: false,
+		},
+		{
+			name:    "is a no-op if MemberRemove reports the member is already gone",
+			machine: machine,
+			objs:    []runtime.Object{cp1, cp2, etcdPod("etcd-cp1", withReadyOption), etcdPod("etcd-cp2", withReadyOption), etcdPod("etcd-cp3", withReadyOption)},
+			etcdClientGenerator: &fakeEtcdClientGenerator{
+				client: &etcd.Client{
+					EtcdClient: &fake2.FakeEtcdClient{
+						MemberRemoveError: errors.New("etcdserver: member not found"),
+						MemberListResponse: &clientv3.MemberListResponse{
+							Members: []*pb.Member{
+								{Name: "cp1", ID: uint64(1), PeerURLs: []string{"https://10.0.0.1:2380"}},
+								{Name: "cp2", ID: uint64(2), PeerURLs: []string{"https://10.0.0.2:2380"}},
+								{Name: "cp3", ID: uint64(3), PeerURLs: []string{"https://10.0.0.3:2380"}},
 							},
 						},
 						AlarmResponse: &clientv3.AlarmResponse{
@@ -318,6 +492,35 @@ func TestRemoveEtcdMemberFromMachine(t *testing.T) {
 			g.Expect(err).ToNot(HaveOccurred())
 		})
 	}
+
+	t.Run("aborts with ErrQuorumViolation if removing the member would drop quorum", func(t *testing.T) {
+		g := NewWithT(t)
+		fakeClient := fake.NewFakeClientWithScheme(scheme, cp1, cp2,
+			etcdPod("etcd-cp1", withReadyOption), etcdPod("etcd-cp2", withReadyOption), etcdPod("etcd-cp3"))
+		fakeEtcdClient := &fake2.FakeEtcdClient{
+			MemberListResponse: &clientv3.MemberListResponse{
+				Members: []*pb.Member{
+					{Name: "cp1", ID: uint64(1), PeerURLs: []string{"https://10.0.0.1:2380"}},
+					{Name: "cp2", ID: uint64(2), PeerURLs: []string{"https://10.0.0.2:2380"}},
+					{Name: "cp3", ID: uint64(3), PeerURLs: []string{"https://10.0.0.3:2380"}},
+				},
+			},
+			AlarmResponse: &clientv3.AlarmResponse{
+				Alarms: []*pb.AlarmMember{},
+			},
+		}
+		w := &Workload{
+			Client: fakeClient,
+			etcdClientGenerator: &fakeEtcdClientGenerator{
+				client: &etcd.Client{EtcdClient: fakeEtcdClient},
+			},
+		}
+
+		err := w.RemoveEtcdMemberForMachine(context.TODO(), machine)
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(errors.Is(err, ErrQuorumViolation)).To(BeTrue())
+		g.Expect(fakeEtcdClient.MemberRemoveCallCount).To(Equal(0))
+	})
 }
 
 func TestForwardEtcdLeadership(t *testing.T) {
@@ -504,6 +707,12 @@ func TestForwardEtcdLeadership(t *testing.T) {
 				}
 
 				w := &Workload{
+					Client: &fakeClient{
+						get: map[string]interface{}{
+							"kube-system/etcd-other-node":  etcdPod("etcd-other-node", withReadyOption),
+							"kube-system/etcd-leader-node": etcdPod("etcd-leader-node", withReadyOption),
+						},
+					},
 					etcdClientGenerator: etcdClientGenerator,
 				}
 				ctx := context.TODO()
@@ -517,14 +726,136 @@ func TestForwardEtcdLeadership(t *testing.T) {
 			})
 		}
 	})
+
+	t.Run("aborts with ErrQuorumViolation if the leader candidate is not ready", func(t *testing.T) {
+		g := NewWithT(t)
+		machine := &clusterv1.Machine{
+			Status: clusterv1.MachineStatus{
+				NodeRef: &corev1.ObjectReference{
+					Name: "machine-node",
+				},
+			},
+		}
+		fakeEtcdClient := &fake2.FakeEtcdClient{
+			MemberListResponse: &clientv3.MemberListResponse{
+				Members: []*pb.Member{
+					{Name: "machine-node", ID: uint64(101)},
+					{Name: "other-node", ID: uint64(1034)},
+				},
+			},
+			AlarmResponse: &clientv3.AlarmResponse{
+				Alarms: []*pb.AlarmMember{},
+			},
+		}
+		w := &Workload{
+			Client: &fakeClient{
+				get: map[string]interface{}{
+					"kube-system/etcd-other-node": etcdPod("etcd-other-node"),
+				},
+			},
+			etcdClientGenerator: &fakeEtcdClientGenerator{
+				client: &etcd.Client{
+					EtcdClient: fakeEtcdClient,
+					LeaderID:   101,
+				},
+			},
+		}
+
+		err := w.ForwardEtcdLeadership(context.TODO(), machine, nil)
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(errors.Is(err, ErrQuorumViolation)).To(BeTrue())
+		g.Expect(fakeEtcdClient.MovedLeader).To(BeEquivalentTo(0))
+	})
+}
+
+func TestEtcdQuorumGuard(t *testing.T) {
+	workload := &Workload{
+		Client: &fakeClient{
+			get: map[string]interface{}{
+				"kube-system/etcd-test-1": etcdPod("etcd-test-1", withReadyOption),
+				"kube-system/etcd-test-2": etcdPod("etcd-test-2", withReadyOption),
+				"kube-system/etcd-test-3": etcdPod("etcd-test-3"),
+			},
+		},
+	}
+	etcdClient := &etcd.Client{
+		EtcdClient: &fake2.FakeEtcdClient{
+			MemberListResponse: &clientv3.MemberListResponse{
+				Members: []*pb.Member{
+					{Name: "test-1", ID: uint64(1)},
+					{Name: "test-2", ID: uint64(2)},
+					{Name: "test-3", ID: uint64(3)},
+				},
+			},
+		},
+	}
+	guard := NewEtcdQuorumGuard(workload)
+
+	t.Run("allows removing an already-unhealthy member", func(t *testing.T) {
+		g := NewWithT(t)
+		g.Expect(guard.AllowRemoval(context.Background(), etcdClient, uint64(3))).To(Succeed())
+	})
+
+	t.Run("blocks removing a healthy member when it would drop the cluster below quorum", func(t *testing.T) {
+		g := NewWithT(t)
+		g.Expect(guard.AllowRemoval(context.Background(), etcdClient, uint64(1))).To(MatchError(ErrQuorumViolation))
+	})
+
+	t.Run("blocks moving leadership to a candidate whose pod is not Ready", func(t *testing.T) {
+		g := NewWithT(t)
+		err := guard.AllowLeadershipMove(context.Background(), &etcd.Member{Name: "test-3", ID: uint64(3)})
+		g.Expect(err).To(MatchError(ErrQuorumViolation))
+	})
+}
+
+func TestWorkload_EtcdQuorumStatus(t *testing.T) {
+	g := NewWithT(t)
+	workload := &Workload{
+		Client: &fakeClient{
+			get: map[string]interface{}{
+				"kube-system/etcd-test-1": etcdPod("etcd-test-1", withReadyOption),
+				"kube-system/etcd-test-2": etcdPod("etcd-test-2", withReadyOption),
+				"kube-system/etcd-test-3": etcdPod("etcd-test-3"),
+			},
+			list: &corev1.NodeList{
+				Items: []corev1.Node{
+					nodeNamed("test-1", withProviderID("my-provider-id-1")),
+				},
+			},
+		},
+		etcdClientGenerator: &fakeEtcdClientGenerator{
+			client: &etcd.Client{
+				EtcdClient: &fake2.FakeEtcdClient{
+					MemberListResponse: &clientv3.MemberListResponse{
+						Members: []*pb.Member{
+							{Name: "test-1", ID: uint64(1)},
+							{Name: "test-2", ID: uint64(2)},
+							{Name: "test-3", ID: uint64(3)},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	status, err := workload.EtcdQuorumStatus(context.Background())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(status).To(Equal(EtcdQuorumStatus{Members: 3, Healthy: 2, Quorum: 2, AtRisk: false}))
 }
 
 type fakeEtcdClientGenerator struct {
 	client *etcd.Client
 	err    error
+	// clients, if set, is checked before client: it allows tests to hand
+	// back a distinct *etcd.Client per node, e.g. to track per-node call
+	// order.
+	clients map[string]*etcd.Client
 }
 
-func (c *fakeEtcdClientGenerator) forNode(_ context.Context, _ string) (*etcd.Client, error) {
+func (c *fakeEtcdClientGenerator) forNode(_ context.Context, name string) (*etcd.Client, error) {
+	if client, ok := c.clients[name]; ok {
+		return client, nil
+	}
 	return c.client, c.err
 }
 
@@ -0,0 +1,145 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fakeClient is a minimal ctrlclient.Client stub used by tests that only
+// need to stage Get/List responses without the overhead of the full
+// controller-runtime fake client and a scheme.
+type fakeClient struct {
+	get map[string]interface{}
+	// list is returned, as-is, for any List call; it is the caller's
+	// responsibility to pass a matching concrete list type.
+	list runtime.Object
+	// created records every object passed to Create, in call order.
+	created []runtime.Object
+	// jobConditions, if set, are stamped onto the Status of any
+	// *batchv1.Job passed to Create, before it is returned by a
+	// subsequent Get for that Job. This lets tests simulate a Job that
+	// is already complete (or failed) by the time it is first polled.
+	jobConditions []batchv1.JobCondition
+	// jobs holds every *batchv1.Job created via Create, keyed by name,
+	// so that Get can serve them back with jobConditions applied.
+	jobs map[string]*batchv1.Job
+}
+
+func (f *fakeClient) Get(_ context.Context, key ctrlclient.ObjectKey, obj runtime.Object) error {
+	if job, ok := obj.(*batchv1.Job); ok {
+		stored, ok := f.jobs[key.Name]
+		if !ok {
+			return errors.Errorf("object not found: %s/%s", key.Namespace, key.Name)
+		}
+		*job = *stored
+		return nil
+	}
+
+	item, ok := f.get[fmt.Sprintf("%s/%s", key.Namespace, key.Name)]
+	if !ok {
+		return errors.Errorf("object not found: %s/%s", key.Namespace, key.Name)
+	}
+	return copyInto(item, obj)
+}
+
+func (f *fakeClient) List(_ context.Context, list runtime.Object, _ ...ctrlclient.ListOption) error {
+	if f.list == nil {
+		return nil
+	}
+	return copyInto(f.list, list)
+}
+
+func (f *fakeClient) Create(_ context.Context, obj runtime.Object, _ ...ctrlclient.CreateOption) error {
+	f.created = append(f.created, obj)
+
+	if job, ok := obj.(*batchv1.Job); ok {
+		if job.Name == "" {
+			job.Name = job.GenerateName + "fake"
+		}
+		job.Status.Conditions = f.jobConditions
+		if f.jobs == nil {
+			f.jobs = map[string]*batchv1.Job{}
+		}
+		f.jobs[job.Name] = job
+	}
+
+	return nil
+}
+
+func (f *fakeClient) Delete(_ context.Context, _ runtime.Object, _ ...ctrlclient.DeleteOption) error {
+	return nil
+}
+
+func (f *fakeClient) Update(_ context.Context, _ runtime.Object, _ ...ctrlclient.UpdateOption) error {
+	return nil
+}
+
+func (f *fakeClient) Patch(_ context.Context, _ runtime.Object, _ ctrlclient.Patch, _ ...ctrlclient.PatchOption) error {
+	return nil
+}
+
+func (f *fakeClient) DeleteAllOf(_ context.Context, _ runtime.Object, _ ...ctrlclient.DeleteAllOfOption) error {
+	return nil
+}
+
+func (f *fakeClient) Status() ctrlclient.StatusWriter {
+	return f
+}
+
+type copier interface {
+	DeepCopyObject() runtime.Object
+}
+
+func copyInto(src interface{}, dst runtime.Object) error {
+	copyable, ok := src.(copier)
+	if !ok {
+		return errors.Errorf("value %T does not support DeepCopyObject", src)
+	}
+	copied := copyable.DeepCopyObject()
+
+	srcVal := reflect.ValueOf(copied)
+	dstVal := reflect.ValueOf(dst)
+	if srcVal.Type() != dstVal.Type() {
+		return errors.Errorf("cannot copy %T into %T", src, dst)
+	}
+	dstVal.Elem().Set(srcVal.Elem())
+	return nil
+}
+
+type nodeOption func(corev1.Node) corev1.Node
+
+func nodeNamed(name string, options ...nodeOption) corev1.Node {
+	node := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+	}
+	for _, opt := range options {
+		node = opt(node)
+	}
+	return node
+}